@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DataSource supplies the dispensary and park datasets. Swapping the
+// source (flat files, a polled HTTP endpoint, or a watched Consul prefix)
+// shouldn't require touching anything downstream of Load/Watch. Each
+// source picks a PlacemarkLoader based on an explicit Format or, if
+// unset, by sniffing/guessing from what it fetched.
+type DataSource interface {
+	// Load fetches and decodes the current dispensary and park datasets
+	// once.
+	Load() (Dispensaries, Parks, error)
+
+	// Watch calls onChange with a freshly decoded dispensary/park dataset
+	// every time the underlying data changes, blocking until it hits an
+	// unrecoverable error. Sources with no notion of change (like
+	// FileSource) call onChange once with the result of Load and return.
+	Watch(onChange func(Dispensaries, Parks)) error
+}
+
+// FileSource reads dispensaries and parks from files on disk. It's the
+// default source, and behaves exactly like the old hard-coded
+// kmlToPlacemarks calls in main used to.
+type FileSource struct {
+	DispensariesPath string
+	ParksPath        string
+	Format           string // "" = auto-detect from file extension
+}
+
+func (s FileSource) loaderFor(path string) (PlacemarkLoader, error) {
+	format := s.Format
+	if format == "" {
+		format = DetectFormat(path)
+	}
+	return LoaderForFormat(format)
+}
+
+func (s FileSource) Load() (Dispensaries, Parks, error) {
+	dispensaryLoader, err := s.loaderFor(s.DispensariesPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	dispensaryFile, err := os.Open(s.DispensariesPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	dispensaries, err := dispensaryLoader.LoadDispensaries(dispensaryFile)
+	dispensaryFile.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parkLoader, err := s.loaderFor(s.ParksPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	parkFile, err := os.Open(s.ParksPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	parks, err := parkLoader.LoadParks(parkFile)
+	parkFile.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dispensaries, parks, nil
+}
+
+func (s FileSource) Watch(onChange func(Dispensaries, Parks)) error {
+	dispensaries, parks, err := s.Load()
+	if err != nil {
+		return err
+	}
+	onChange(dispensaries, parks)
+	return nil
+}
+
+// HTTPSource polls dispensaries and parks documents over HTTP, using
+// ETag/Last-Modified caching so unchanged polls are cheap.
+type HTTPSource struct {
+	DispensariesURL string
+	ParksURL        string
+	PollInterval    time.Duration
+	Format          string // "" = auto-detect from URL extension
+	Client          *http.Client
+}
+
+func (s HTTPSource) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s HTTPSource) interval() time.Duration {
+	if s.PollInterval > 0 {
+		return s.PollInterval
+	}
+	return 30 * time.Second
+}
+
+func (s HTTPSource) loaderFor(url string) (PlacemarkLoader, error) {
+	format := s.Format
+	if format == "" {
+		format = DetectFormat(url)
+	}
+	return LoaderForFormat(format)
+}
+
+// httpCacheState tracks the validators needed to make a conditional GET.
+type httpCacheState struct {
+	etag         string
+	lastModified string
+}
+
+// fetchIfChanged issues a conditional GET and reports whether the
+// response body actually changed, so pollers can skip redundant decodes.
+func fetchIfChanged(client *http.Client, url string, cache httpCacheState) (body []byte, next httpCacheState, changed bool, err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, cache, false, err
+	}
+	if cache.etag != "" {
+		req.Header.Set("If-None-Match", cache.etag)
+	}
+	if cache.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.lastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, cache, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, cache, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, cache, false, fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, cache, false, err
+	}
+
+	next = httpCacheState{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+	return body, next, true, nil
+}
+
+func (s HTTPSource) Load() (Dispensaries, Parks, error) {
+	client := s.client()
+
+	dispensaryLoader, err := s.loaderFor(s.DispensariesURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	dispensaryBytes, _, _, err := fetchIfChanged(client, s.DispensariesURL, httpCacheState{})
+	if err != nil {
+		return nil, nil, err
+	}
+	dispensaries, err := dispensaryLoader.LoadDispensaries(bytes.NewReader(dispensaryBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parkLoader, err := s.loaderFor(s.ParksURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	parkBytes, _, _, err := fetchIfChanged(client, s.ParksURL, httpCacheState{})
+	if err != nil {
+		return nil, nil, err
+	}
+	parks, err := parkLoader.LoadParks(bytes.NewReader(parkBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dispensaries, parks, nil
+}
+
+func (s HTTPSource) Watch(onChange func(Dispensaries, Parks)) error {
+	client := s.client()
+
+	dispensaryLoader, err := s.loaderFor(s.DispensariesURL)
+	if err != nil {
+		return err
+	}
+	parkLoader, err := s.loaderFor(s.ParksURL)
+	if err != nil {
+		return err
+	}
+
+	var dispensaryCache, parkCache httpCacheState
+	var dispensaries Dispensaries
+	var parks Parks
+
+	for {
+		dispensaryBytes, newDispensaryCache, dispensaryChanged, err := fetchIfChanged(client, s.DispensariesURL, dispensaryCache)
+		if err != nil {
+			return err
+		}
+		if dispensaryChanged {
+			dispensaries, err = dispensaryLoader.LoadDispensaries(bytes.NewReader(dispensaryBytes))
+			if err != nil {
+				return err
+			}
+			dispensaryCache = newDispensaryCache
+		}
+
+		parkBytes, newParkCache, parkChanged, err := fetchIfChanged(client, s.ParksURL, parkCache)
+		if err != nil {
+			return err
+		}
+		if parkChanged {
+			parks, err = parkLoader.LoadParks(bytes.NewReader(parkBytes))
+			if err != nil {
+				return err
+			}
+			parkCache = newParkCache
+		}
+
+		if dispensaryChanged || parkChanged {
+			onChange(dispensaries, parks)
+		}
+
+		time.Sleep(s.interval())
+	}
+}
+
+// ConsulKV watches two keys under Prefix ("<Prefix>/dispensaries" and
+// "<Prefix>/parks") using Consul's blocking KV queries, so operators can
+// update the dataset by writing to Consul instead of redeploying. Values
+// may be stored as either KML or GeoJSON; the format is auto-detected by
+// sniffing the blob unless Format is set.
+type ConsulKV struct {
+	Addr   string // e.g. "http://127.0.0.1:8500"
+	Prefix string
+	Format string // "" = auto-detect (kml or geojson)
+	Client *http.Client
+}
+
+type consulKVEntry struct {
+	Value string `json:"Value"` // base64-encoded, per the Consul KV API
+}
+
+func (s ConsulKV) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s ConsulKV) key(name string) string {
+	return strings.TrimRight(s.Prefix, "/") + "/" + name
+}
+
+// loaderFor picks the loader for a fetched blob: Format if set, otherwise
+// a sniff of the first non-whitespace byte (GeoJSON is a JSON object,
+// KML is XML).
+func (s ConsulKV) loaderFor(data []byte) (PlacemarkLoader, error) {
+	if s.Format != "" {
+		return LoaderForFormat(s.Format)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return GeoJSONLoader{}, nil
+	}
+	return KMLLoader{}, nil
+}
+
+// fetchBlocking performs a Consul blocking query against a single key,
+// returning the decoded value and the X-Consul-Index to pass back in on
+// the next call. It blocks (up to Consul's wait timeout) until the index
+// advances past the one supplied.
+func (s ConsulKV) fetchBlocking(name string, index uint64) (data []byte, newIndex uint64, err error) {
+	url := fmt.Sprintf("%s/v1/kv/%s?index=%d&wait=5m", strings.TrimRight(s.Addr, "/"), s.key(name), index)
+
+	resp, err := s.client().Get(url)
+	if err != nil {
+		return nil, index, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, index, fmt.Errorf("consul key %s not found", s.key(name))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, index, fmt.Errorf("unexpected status from consul: %s", resp.Status)
+	}
+
+	var entries []consulKVEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, index, err
+	}
+	if len(entries) == 0 {
+		return nil, index, fmt.Errorf("consul key %s has no value", s.key(name))
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(entries[0].Value)
+	if err != nil {
+		return nil, index, err
+	}
+
+	newIndex, err = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		newIndex = index
+	}
+
+	return decoded, newIndex, nil
+}
+
+func (s ConsulKV) Load() (Dispensaries, Parks, error) {
+	dispensaryBytes, _, err := s.fetchBlocking("dispensaries", 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	dispensaryLoader, err := s.loaderFor(dispensaryBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	dispensaries, err := dispensaryLoader.LoadDispensaries(bytes.NewReader(dispensaryBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parkBytes, _, err := s.fetchBlocking("parks", 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	parkLoader, err := s.loaderFor(parkBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	parks, err := parkLoader.LoadParks(bytes.NewReader(parkBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dispensaries, parks, nil
+}
+
+func (s ConsulKV) Watch(onChange func(Dispensaries, Parks)) error {
+	var dispensaryIndex, parkIndex uint64
+	var dispensaries Dispensaries
+	var parks Parks
+
+	for {
+		dispensaryBytes, newDispensaryIndex, err := s.fetchBlocking("dispensaries", dispensaryIndex)
+		if err != nil {
+			return err
+		}
+		dispensaryChanged := newDispensaryIndex != dispensaryIndex
+		if dispensaryChanged {
+			dispensaryLoader, err := s.loaderFor(dispensaryBytes)
+			if err != nil {
+				return err
+			}
+			dispensaries, err = dispensaryLoader.LoadDispensaries(bytes.NewReader(dispensaryBytes))
+			if err != nil {
+				return err
+			}
+			dispensaryIndex = newDispensaryIndex
+		}
+
+		parkBytes, newParkIndex, err := s.fetchBlocking("parks", parkIndex)
+		if err != nil {
+			return err
+		}
+		parkChanged := newParkIndex != parkIndex
+		if parkChanged {
+			parkLoader, err := s.loaderFor(parkBytes)
+			if err != nil {
+				return err
+			}
+			parks, err = parkLoader.LoadParks(bytes.NewReader(parkBytes))
+			if err != nil {
+				return err
+			}
+			parkIndex = newParkIndex
+		}
+
+		if dispensaryChanged || parkChanged {
+			onChange(dispensaries, parks)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/paulsmith/gogeos/geos"
+)
+
+// GeoJSONLoader decodes a standard GeoJSON FeatureCollection: Point
+// features become Dispensaries, Polygon/MultiPolygon features become
+// Parks.
+type GeoJSONLoader struct{}
+
+type geoJSONFeatureCollection struct {
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	// Properties is decoded as raw JSON rather than map[string]string:
+	// real-world exports commonly mix string properties with numbers,
+	// bools, or nested objects (e.g. a numeric id), and a single such
+	// value would otherwise fail json.Unmarshal and abort decoding the
+	// whole FeatureCollection. geoJSONPropertyString coerces the fields
+	// this loader actually reads.
+	Properties map[string]json.RawMessage `json:"properties"`
+	Geometry   geoJSONGeometry            `json:"geometry"`
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func decodeGeoJSON(r io.Reader) (geoJSONFeatureCollection, error) {
+	var collection geoJSONFeatureCollection
+	err := json.NewDecoder(r).Decode(&collection)
+	return collection, err
+}
+
+func (l GeoJSONLoader) LoadDispensaries(r io.Reader) (Dispensaries, error) {
+	collection, err := decodeGeoJSON(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var dispensaries Dispensaries
+	for _, feature := range collection.Features {
+		if feature.Geometry.Type != "Point" {
+			continue
+		}
+
+		var coord [2]float64
+		if err := json.Unmarshal(feature.Geometry.Coordinates, &coord); err != nil {
+			return nil, err
+		}
+
+		geometry, err := geos.NewPoint(geos.NewCoord(coord[0], coord[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		dispensaries = append(dispensaries, Dispensary{Placemark: placemarkFromProperties(feature.Properties, geometry)})
+	}
+	return dispensaries, nil
+}
+
+func (l GeoJSONLoader) LoadParks(r io.Reader) (Parks, error) {
+	collection, err := decodeGeoJSON(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var parks Parks
+	for _, feature := range collection.Features {
+		geometry, ok, err := geoJSONPolygonGeometry(feature.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		parks = append(parks, Park{Placemark: placemarkFromProperties(feature.Properties, geometry)})
+	}
+	return parks, nil
+}
+
+// geoJSONPolygonGeometry builds a geos.Geometry from a Polygon or
+// MultiPolygon GeoJSON geometry, using only each ring's outer boundary
+// (holes aren't modeled by this project's Park type). MultiPolygons are
+// preserved as a geos.NewCollection rather than collapsed to their first
+// polygon.
+func geoJSONPolygonGeometry(geometry geoJSONGeometry) (*geos.Geometry, bool, error) {
+	switch geometry.Type {
+	case "Polygon":
+		var rings [][][2]float64
+		if err := json.Unmarshal(geometry.Coordinates, &rings); err != nil {
+			return nil, false, err
+		}
+		polygon, err := geoJSONRingToPolygon(rings)
+		if err != nil {
+			return nil, false, err
+		}
+		return polygon, true, nil
+
+	case "MultiPolygon":
+		var polygonsCoords [][][][2]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygonsCoords); err != nil {
+			return nil, false, err
+		}
+
+		polygons := make([]*geos.Geometry, 0, len(polygonsCoords))
+		for _, rings := range polygonsCoords {
+			polygon, err := geoJSONRingToPolygon(rings)
+			if err != nil {
+				return nil, false, err
+			}
+			polygons = append(polygons, polygon)
+		}
+
+		if len(polygons) == 1 {
+			return polygons[0], true, nil
+		}
+		collection, err := geos.NewCollection(geos.MULTIPOLYGON, polygons...)
+		if err != nil {
+			return nil, false, err
+		}
+		return collection, true, nil
+
+	default:
+		return nil, false, nil
+	}
+}
+
+func geoJSONRingToPolygon(rings [][][2]float64) (*geos.Geometry, error) {
+	if len(rings) == 0 {
+		return nil, fmt.Errorf("geojson polygon has no rings")
+	}
+
+	shell := make([]geos.Coord, len(rings[0]))
+	for i, coord := range rings[0] {
+		shell[i] = geos.NewCoord(coord[0], coord[1])
+	}
+
+	return geos.NewPolygon(shell)
+}
+
+func placemarkFromProperties(properties map[string]json.RawMessage, geometry *geos.Geometry) Placemark {
+	return Placemark{
+		Name:        geoJSONPropertyString(properties, "name"),
+		Description: geoJSONPropertyString(properties, "description"),
+		Address:     geoJSONPropertyString(properties, "address"),
+		Geometry:    geometry,
+	}
+}
+
+// geoJSONPropertyString reads properties[key] as a string, returning "" if
+// it's absent or isn't a JSON string (a number, bool, null, or object,
+// none of which this project ever expects for name/description/address).
+func geoJSONPropertyString(properties map[string]json.RawMessage, key string) string {
+	raw, ok := properties[key]
+	if !ok {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return ""
+	}
+	return s
+}
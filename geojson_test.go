@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestGeoJSONLoaderToleratesNonStringProperties checks that a Feature
+// with a non-string property (a numeric id, here, but any of number,
+// bool, null, or object would do) doesn't abort decoding the whole
+// FeatureCollection, and that string properties this loader reads are
+// still coerced correctly.
+func TestGeoJSONLoaderToleratesNonStringProperties(t *testing.T) {
+	const input = `{
+		"type": "FeatureCollection",
+		"features": [
+			{
+				"type": "Feature",
+				"properties": {"id": 42, "name": "Mile High Dispensary"},
+				"geometry": {"type": "Point", "coordinates": [-104.99, 39.74]}
+			}
+		]
+	}`
+
+	dispensaries, err := GeoJSONLoader{}.LoadDispensaries(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dispensaries) != 1 {
+		t.Fatalf("got %d dispensaries, want 1", len(dispensaries))
+	}
+	if got := dispensaries[0].Name; got != "Mile High Dispensary" {
+		t.Fatalf("name = %q, want %q", got, "Mile High Dispensary")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/paulsmith/gogeos/geos"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// nearestParkResult is the JSON shape returned by GET /nearest-park and
+// GET /parks/near.
+type nearestParkResult struct {
+	Store          string  `json:"store,omitempty"`
+	Park           string  `json:"park"`
+	DistanceMeters float64 `json:"distance_meters"`
+}
+
+// Server exposes a QueryEngine over HTTP: nearest-park/parks-near queries
+// plus a /metrics endpoint for Prometheus scraping. The engine is read
+// from currentEngine on every request rather than captured once, so a
+// background dataset reload (see serve, in main.go) never needs to
+// restart the listener.
+type Server struct {
+	currentEngine *atomic.Value // holds *QueryEngine
+	mux           *http.ServeMux
+}
+
+// NewServer builds a Server that always dispatches to whatever
+// *QueryEngine is currently stored in currentEngine.
+func NewServer(currentEngine *atomic.Value) *Server {
+	s := &Server{currentEngine: currentEngine, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/nearest-park", s.handleNearestPark)
+	s.mux.HandleFunc("/parks/near", s.handleParksNear)
+	s.mux.Handle("/metrics", promhttp.Handler())
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) engine() *QueryEngine {
+	return s.currentEngine.Load().(*QueryEngine)
+}
+
+// handleNearestPark answers GET /nearest-park?lat=..&lng=..&k=5 with the
+// k dispensaries closest to (lat, lng) and each one's nearest park.
+func (s *Server) handleNearestPark(w http.ResponseWriter, r *http.Request) {
+	lat, err := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	if err != nil {
+		http.Error(w, "invalid or missing lat", http.StatusBadRequest)
+		return
+	}
+	lng, err := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+	if err != nil {
+		http.Error(w, "invalid or missing lng", http.StatusBadRequest)
+		return
+	}
+	k, err := queryK(r, 1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	point, err := geos.NewPoint(geos.NewCoord(lng, lat))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	matches, err := s.engine().NearestPark(point, k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, parkDistancesToResults(matches, true))
+}
+
+// handleParksNear answers GET /parks/near?geometry=<geojson>&k=5 with the
+// k parks closest to the given polygon (by its centroid).
+func (s *Server) handleParksNear(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("geometry")
+	if raw == "" {
+		http.Error(w, "missing geometry", http.StatusBadRequest)
+		return
+	}
+
+	var geometryDoc geoJSONGeometry
+	if err := json.Unmarshal([]byte(raw), &geometryDoc); err != nil {
+		http.Error(w, fmt.Sprintf("invalid geometry: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	geometry, ok, err := geoJSONPolygonGeometry(geometryDoc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(w, "geometry must be a Polygon or MultiPolygon", http.StatusBadRequest)
+		return
+	}
+
+	k, err := queryK(r, 5)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	matches, err := s.engine().ParksNear(geometry, k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, parkDistancesToResults(matches, false))
+}
+
+// queryK parses the optional ?k= query parameter, falling back to
+// defaultK when it's absent.
+func queryK(r *http.Request, defaultK int) (int, error) {
+	raw := r.URL.Query().Get("k")
+	if raw == "" {
+		return defaultK, nil
+	}
+
+	k, err := strconv.Atoi(raw)
+	if err != nil || k < 1 {
+		return 0, fmt.Errorf("invalid k %q", raw)
+	}
+	return k, nil
+}
+
+func parkDistancesToResults(matches []ParkDistance, includeStore bool) []nearestParkResult {
+	results := make([]nearestParkResult, len(matches))
+	for i, match := range matches {
+		result := nearestParkResult{Park: match.park.Name, DistanceMeters: match.distance}
+		if includeStore {
+			result.Store = match.store.Name
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
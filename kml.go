@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/paulsmith/gogeos/geos"
+)
+
+// KMLLoader decodes the original KML export format: a
+// <kml><Document><Folder><Placemark> per dispensary or park, points
+// stored as "lon,lat,0 " coordinate strings and polygons as one or more
+// MultiGeometry>Polygon>outerBoundaryIs>LinearRing>coordinates blocks.
+type KMLLoader struct{}
+
+// kmlDocument mirrors the raw KML tree the Go XML decoder wants; kept
+// private so the format's XML shape doesn't leak into the domain types.
+type kmlDocument struct {
+	XMLName    xml.Name          `xml:"kml"`
+	Placemarks []kmlPlacemarkDoc `xml:"Document>Folder>Placemark"`
+}
+
+type kmlPlacemarkDoc struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	Address     string `xml:"address"`
+
+	PointCoords string `xml:"Point>coordinates"`
+
+	// A Placemark with a single polygon uses Polygon directly; one with
+	// several (a MultiPolygon) nests them under MultiGeometry. Both are
+	// collected so LoadParks can build the right geometry either way.
+	SinglePolygonCoords string   `xml:"Polygon>outerBoundaryIs>LinearRing>coordinates"`
+	MultiPolygonCoords  []string `xml:"MultiGeometry>Polygon>outerBoundaryIs>LinearRing>coordinates"`
+}
+
+func (l KMLLoader) decode(r io.Reader) (kmlDocument, error) {
+	var doc kmlDocument
+	err := xml.NewDecoder(r).Decode(&doc)
+	return doc, err
+}
+
+func (l KMLLoader) LoadDispensaries(r io.Reader) (Dispensaries, error) {
+	doc, err := l.decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var dispensaries Dispensaries
+	for _, placemark := range doc.Placemarks {
+		coords := transformKMLCoordinates(placemark.PointCoords)
+		if coords == nil {
+			continue
+		}
+
+		geometry, err := geos.NewPoint(coords[0])
+		if err != nil {
+			return nil, err
+		}
+
+		dispensaries = append(dispensaries, Dispensary{Placemark: Placemark{
+			Name:        placemark.Name,
+			Description: placemark.Description,
+			Address:     placemark.Address,
+			Geometry:    geometry,
+		}})
+	}
+	return dispensaries, nil
+}
+
+func (l KMLLoader) LoadParks(r io.Reader) (Parks, error) {
+	doc, err := l.decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var parks Parks
+	for _, placemark := range doc.Placemarks {
+		polygonCoords := placemark.MultiPolygonCoords
+		if len(polygonCoords) == 0 && placemark.SinglePolygonCoords != "" {
+			polygonCoords = []string{placemark.SinglePolygonCoords}
+		}
+		if len(polygonCoords) == 0 {
+			continue
+		}
+
+		geometry, err := kmlPolygonsToGeometry(polygonCoords)
+		if err != nil {
+			return nil, err
+		}
+
+		parks = append(parks, Park{Placemark: Placemark{
+			Name:        placemark.Name,
+			Description: placemark.Description,
+			Address:     placemark.Address,
+			Geometry:    geometry,
+		}})
+	}
+	return parks, nil
+}
+
+// kmlPolygonsToGeometry turns one or more LinearRing coordinate strings
+// into a single geometry: a plain Polygon for one ring, or a
+// geos.NewCollection MULTIPOLYGON when the Placemark is a MultiGeometry
+// with several. Previously only the first ring was ever read, silently
+// dropping the rest of a MultiPolygon park.
+func kmlPolygonsToGeometry(polygonCoords []string) (*geos.Geometry, error) {
+	polygons := make([]*geos.Geometry, 0, len(polygonCoords))
+	for _, raw := range polygonCoords {
+		coords := transformKMLCoordinates(raw)
+		polygon, err := geos.NewPolygon(coords)
+		if err != nil {
+			return nil, err
+		}
+		polygons = append(polygons, polygon)
+	}
+
+	if len(polygons) == 1 {
+		return polygons[0], nil
+	}
+	return geos.NewCollection(geos.MULTIPOLYGON, polygons...)
+}
+
+// transformKMLCoordinates takes a KML coordinate string ("lon,lat,0
+// lon,lat,0 ...") and converts it to geos.Coord pairs.
+func transformKMLCoordinates(coordinates string) (geosCoords []geos.Coord) {
+	trimmed := strings.TrimSpace(coordinates)
+	if trimmed == "" {
+		return nil
+	}
+	coordGroups := strings.Split(trimmed, " ")
+
+	for _, raw := range coordGroups {
+		trimmedGroup := strings.TrimRight(raw, ",0")
+		pair := strings.Split(trimmedGroup, ",")
+		if len(pair) < 2 {
+			return nil
+		}
+
+		x, err := strconv.ParseFloat(pair[0], 64)
+		if err != nil {
+			return nil
+		}
+
+		y, err := strconv.ParseFloat(pair[1], 64)
+		if err != nil {
+			return nil
+		}
+
+		geosCoords = append(geosCoords, geos.NewCoord(x, y))
+	}
+
+	return geosCoords
+}
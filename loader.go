@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// PlacemarkLoader decodes a dispensary or park dataset from a reader,
+// independent of the underlying file format. KML, GeoJSON, and ESRI
+// Shapefile all implement it, and DataSource implementations pick one
+// based on an explicit --format flag or, if unset, the file extension
+// (FileSource, HTTPSource) or a sniff of the fetched content (ConsulKV).
+type PlacemarkLoader interface {
+	LoadDispensaries(r io.Reader) (Dispensaries, error)
+	LoadParks(r io.Reader) (Parks, error)
+}
+
+// LoaderForFormat resolves a format name (as passed via --format, or
+// guessed by DetectFormat) to the loader that handles it.
+func LoaderForFormat(format string) (PlacemarkLoader, error) {
+	switch strings.ToLower(format) {
+	case "kml":
+		return KMLLoader{}, nil
+	case "geojson", "json":
+		return GeoJSONLoader{}, nil
+	case "shapefile", "shp":
+		return ShapefileLoader{}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q (want kml, geojson, or shapefile)", format)
+	}
+}
+
+// DetectFormat guesses a format name from a file path's extension. It
+// defaults to "kml" for anything unrecognized, matching this project's
+// original KML-only behavior.
+func DetectFormat(path string) string {
+	// strip a query string, in case path is actually a URL
+	if idx := strings.IndexByte(path, '?'); idx != -1 {
+		path = path[:idx]
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".geojson", ".json":
+		return "geojson"
+	case ".shp":
+		return "shapefile"
+	default:
+		return "kml"
+	}
+}
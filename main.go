@@ -1,136 +1,51 @@
 package main
 
 import (
-	"encoding/xml"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"net/http"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/paulsmith/gogeos/geos"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
 	workers int = 30
+
+	defaultDispensariesPath = "assets/dispensaries.kml"
+	defaultParksPath        = "assets/parks.kml"
 )
 
 // convenience types for arrays
 type Dispensaries []Dispensary
 type Parks []Park
 
-type DispensaryKml struct {
-	XMLName      xml.Name     `xml:"kml"`
-	Dispensaries Dispensaries `xml:"Document>Folder>Placemark"`
-}
-
-type ParkKml struct {
-	XMLName xml.Name `xml:"kml"`
-	Parks   Parks    `xml:"Document>Folder>Placemark"`
-}
-
-// base type for KML Placemark objects
+// base type for Placemark objects, independent of the format (KML,
+// GeoJSON, Shapefile) they were loaded from. See PlacemarkLoader.
 type Placemark struct {
-	Name        string `xml:"name"`
-	Description string `xml:"description"`
-	Address     string `xml:"address"`
+	Name        string
+	Description string
+	Address     string
 	Geometry    *geos.Geometry
 	rating      int
 }
 
-// Dispensary type using the Placemark as a mixin
-// with point coordinates
+// Dispensary type using the Placemark as a mixin, backed by a point
+// geometry
 type Dispensary struct {
 	Placemark
-	PointCoords string `xml:"Point>coordinates"`
 }
 
-// Park type using the Placemark as a mixin
-// with polygon coordinates
+// Park type using the Placemark as a mixin, backed by a polygon (or, for
+// a MultiPolygon park, a geometry collection of polygons)
 type Park struct {
 	Placemark
-	LinearRingCoords string `xml:"MultiGeometry>Polygon>outerBoundaryIs>LinearRing>coordinates"`
-}
-
-// custom XML unmarshal function for Dispensary placemarks
-func (dispensaries *Dispensaries) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	dispensary := &Dispensary{}
-
-	err := d.DecodeElement(dispensary, &start)
-	if err != nil {
-		return nil
-	}
-
-	coords := transformCoordinates(dispensary.PointCoords)
-	if coords == nil {
-		return nil
-	}
-
-	dispensary.Geometry, err = geos.NewPoint(coords[0])
-	if err != nil {
-		return nil
-	}
-
-	newSlice := []Dispensary(*dispensaries)
-	*dispensaries = append(newSlice, *dispensary)
-
-	return nil
-}
-
-// custom XML unmarshal function for Park placemarks
-func (parks *Parks) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	park := &Park{}
-
-	err := d.DecodeElement(park, &start)
-	if err != nil {
-		return nil
-	}
-
-	coords := transformCoordinates(park.LinearRingCoords)
-
-	park.Geometry, err = geos.NewPolygon(coords)
-	if err != nil {
-		return nil
-	}
-
-	newSlice := []Park(*parks)
-	*parks = append(newSlice, *park)
-
-	return nil
-}
-
-// takes a string of polygon coordinates and convert
-// the pairs first to floats and then to an array
-// of geos.Coord objects
-func transformCoordinates(coordinates string) (geosCoords []geos.Coord) {
-	trimmed := strings.TrimSpace(coordinates)
-	coordGroups := strings.Split(trimmed, " ")
-	coordPairs := make([][]string, len(coordGroups))
-
-	for idx, raw := range coordGroups {
-		trimmedGroup := strings.TrimRight(raw, ",0")
-		pair := strings.Split(trimmedGroup, ",")
-		coordPairs[idx] = pair
-	}
-
-	for _, pair := range coordPairs {
-		x, err := strconv.ParseFloat(pair[0], 64)
-		if err != nil {
-			return nil
-		}
-
-		y, err := strconv.ParseFloat(pair[1], 64)
-		if err != nil {
-			return nil
-		}
-
-		geosCoords = append(geosCoords, geos.NewCoord(x, y))
-	}
-
-	return geosCoords
 }
 
 // takes a geos.Geometry object and returns its lat/lng (X/Y)
@@ -141,30 +56,6 @@ func extractLatLng(geometry *geos.Geometry) (lat, lng float64) {
 	return lat, lng
 }
 
-// an example using the empty interface to take any object.
-// hands of to Go's XML library for unmarshalling
-func kmlToPlacemarks(kmlFileName string, intf interface{}) {
-	// declare some vars
-	var kmlFile []byte
-	var err error
-
-	// read the dispensaries file into the byte array
-	kmlFile, err = ioutil.ReadFile(kmlFileName)
-
-	// common error checking pattern in Go
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
-	// read the byte array into the struct instance.
-	err = xml.Unmarshal(kmlFile, intf)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-}
-
 // a type to contain a park and its distance
 // from a dispensary
 type ParkDistance struct {
@@ -173,37 +64,6 @@ type ParkDistance struct {
 	distance float64
 }
 
-func nearestParks(wg *sync.WaitGroup, parks []Park, input <-chan Dispensary, output chan<- *ParkDistance) {
-
-	// defer a function to signal the waitgroup that this worker is complete
-	// runs regardless of errors/panic
-	defer wg.Done()
-
-	// range is channel aware, will loop and block
-	// until the channel is closed
-	for store := range input {
-		// use a pointer for the convenience of nil
-		var nearest *ParkDistance
-
-		for _, park := range parks {
-			// artificial latency!
-			time.Sleep(100 * time.Microsecond)
-			distance, err := store.Geometry.Distance(park.Geometry)
-
-			if err == nil {
-				if nearest == nil || distance < nearest.distance {
-					nearest = &ParkDistance{store: store, park: park, distance: distance}
-				}
-			} else {
-				fmt.Println(err)
-			}
-		}
-
-		// push the result onto the output channel
-		output <- nearest
-	}
-}
-
 // a worker that reads from a result channel and does some tracking to
 // find the shortest distance among them
 func nearestPair(wg *sync.WaitGroup, results <-chan *ParkDistance) chan *ParkDistance {
@@ -244,55 +104,71 @@ func printStore(store Dispensary) {
 	fmt.Println(fmt.Sprintf("%s\n%s\n%s", store.Name, cleanAddress, mapUrl))
 }
 
-func main() {
-	var storeKml DispensaryKml
-	var parkKml ParkKml
-
-	kmlToPlacemarks("assets/dispensaries.kml", &storeKml)
-	kmlToPlacemarks("assets/parks.kml", &parkKml)
-
-	stores := storeKml.Dispensaries
-	parks := parkKml.Parks
+// newDataSource builds the DataSource selected by --source, using the
+// flags relevant to that backend and sane defaults for the rest.
+func newDataSource(source string) (DataSource, error) {
+	switch source {
+	case "file":
+		return FileSource{DispensariesPath: *dispensariesPath, ParksPath: *parksPath, Format: *formatFlag}, nil
+	case "http":
+		return HTTPSource{DispensariesURL: *dispensariesURL, ParksURL: *parksURL, PollInterval: *pollInterval, Format: *formatFlag}, nil
+	case "consul":
+		return ConsulKV{Addr: *consulAddr, Prefix: *consulPrefix, Format: *formatFlag}, nil
+	default:
+		return nil, fmt.Errorf("unknown --source %q (want file, http, or consul)", source)
+	}
+}
 
-	// create channels for the work queue and the results
-	// these channels are buffered to avoid blocking writes
-	// we have a small data set, so just use that buffer size
-	storeCount := len(stores)
-	workQueue := make(chan Dispensary, storeCount)
-	results := make(chan *ParkDistance, storeCount)
+var (
+	sourceFlag       = flag.String("source", "file", "data source backend: file, http, or consul")
+	formatFlag       = flag.String("format", "", "placemark format: kml, geojson, or shapefile (default: auto-detect)")
+	dispensariesPath = flag.String("dispensaries-path", defaultDispensariesPath, "path to the dispensaries file (--source=file)")
+	parksPath        = flag.String("parks-path", defaultParksPath, "path to the parks file (--source=file)")
+	dispensariesURL  = flag.String("dispensaries-url", "", "URL to poll for the dispensaries document (--source=http)")
+	parksURL         = flag.String("parks-url", "", "URL to poll for the parks document (--source=http)")
+	pollInterval     = flag.Duration("poll-interval", 30*time.Second, "how often to poll the HTTP source (--source=http)")
+	consulAddr       = flag.String("consul-addr", "http://127.0.0.1:8500", "Consul HTTP API address (--source=consul)")
+	consulPrefix     = flag.String("consul-prefix", "bunny", "Consul KV prefix holding the dispensaries/parks keys (--source=consul)")
+	httpAddr         = flag.String("http-addr", "", "if set, run as a long-running HTTP service listening on this address instead of a one-shot query")
+	projectionFlag   = flag.String("projection", "auto", "distance projection: auto (UTM zone from dataset centroid), haversine, or epsg:<code>")
+)
 
-	// create a wait group to track worker completion
-	var wg sync.WaitGroup
+func main() {
+	flag.Parse()
 
-	// start up our workers, incrementing the WaitGroup each time
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go nearestParks(&wg, parks, workQueue, results)
+	source, err := newDataSource(*sourceFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	finalResult := nearestPair(&wg, results)
-
-	// all workers are waiting for input
+	metrics := NewMetrics(prometheus.DefaultRegisterer)
 
-	// queue up all of the stores for distance checks
-	for _, store := range stores {
-		workQueue <- store
+	if *httpAddr != "" {
+		if err := serve(source, metrics, *httpAddr, *projectionFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// once we've queued all stores, we close the channel so
-	// the workers can exit
-	close(workQueue)
-
-	// if we don't wait, we have a race condition. make sure
-	// all workers finish their jobs and exit
-	wg.Wait()
+	stores, parks, err := source.Load()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	// all the workers have completed, so we can close the results
-	// channel, which tells the collector it can exit when it's done
-	close(results)
+	engine, err := NewQueryEngine(stores, parks, metrics, *projectionFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	// blocks until the channel has data.
-	nearest := <-finalResult
+	nearest, err := engine.NearestOverall()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	fmt.Println(strings.Repeat("=", 80))
 	fmt.Println("Nearest To A Park")
@@ -300,5 +176,46 @@ func main() {
 	fmt.Println()
 
 	printStore(nearest.store)
-	fmt.Println(fmt.Sprintf("nearest park: %s, %f", nearest.park.Name, nearest.distance))
+	fmt.Println(fmt.Sprintf("nearest park: %s, %.1f m", nearest.park.Name, nearest.distance))
+}
+
+// serve runs bunny as a long-running HTTP service: it loads the initial
+// dataset, starts answering queries against it, and swaps in a freshly
+// built QueryEngine whenever source reports the dataset changed, without
+// ever restarting the listener.
+func serve(source DataSource, metrics *Metrics, addr string, projSpec string) error {
+	var currentEngine atomic.Value
+
+	build := func(dispensaries Dispensaries, parks Parks) (*QueryEngine, error) {
+		return NewQueryEngine(dispensaries, parks, metrics, projSpec)
+	}
+
+	dispensaries, parks, err := source.Load()
+	if err != nil {
+		return err
+	}
+
+	engine, err := build(dispensaries, parks)
+	if err != nil {
+		return err
+	}
+	currentEngine.Store(engine)
+
+	go func() {
+		onChange := func(dispensaries Dispensaries, parks Parks) {
+			engine, err := build(dispensaries, parks)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			currentEngine.Store(engine)
+		}
+		if err := source.Watch(onChange); err != nil {
+			fmt.Println(err)
+		}
+	}()
+
+	server := NewServer(&currentEngine)
+	fmt.Printf("listening on %s\n", addr)
+	return http.ListenAndServe(addr, server)
 }
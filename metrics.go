@@ -0,0 +1,64 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics holds the Prometheus collectors QueryEngine reports against.
+// They're registered once, when the metrics set is built, so rebuilding
+// the engine on a dataset reload doesn't re-register (and panic on)
+// duplicate collectors.
+type Metrics struct {
+	QueryLatency         *prometheus.HistogramVec
+	DistanceComputations prometheus.Counter
+	WorkerJobsProcessed  prometheus.Counter
+	DispensariesLoaded   prometheus.Gauge
+	ParksLoaded          prometheus.Gauge
+	IndexBuildSeconds    prometheus.Gauge
+}
+
+// NewMetrics creates the collectors and registers them against registry
+// (typically prometheus.DefaultRegisterer).
+func NewMetrics(registry prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		QueryLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bunny_query_duration_seconds",
+			Help:    "Latency of QueryEngine queries, by endpoint.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"endpoint"}),
+
+		DistanceComputations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bunny_distance_computations_total",
+			Help: "Total number of distance results produced by QueryEngine queries (one per nearest-neighbor match); distance evaluations made inside the spatial index walk are not counted.",
+		}),
+
+		WorkerJobsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bunny_worker_jobs_processed_total",
+			Help: "Total number of dispensary jobs processed by the nearest-park worker pool.",
+		}),
+
+		DispensariesLoaded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "bunny_dispensaries_loaded",
+			Help: "Number of dispensaries currently loaded in memory.",
+		}),
+
+		ParksLoaded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "bunny_parks_loaded",
+			Help: "Number of parks currently loaded in memory.",
+		}),
+
+		IndexBuildSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "bunny_index_build_seconds",
+			Help: "How long the most recent spatial index build took, in seconds.",
+		}),
+	}
+
+	registry.MustRegister(
+		metrics.QueryLatency,
+		metrics.DistanceComputations,
+		metrics.WorkerJobsProcessed,
+		metrics.DispensariesLoaded,
+		metrics.ParksLoaded,
+		metrics.IndexBuildSeconds,
+	)
+
+	return metrics
+}
@@ -0,0 +1,97 @@
+package main
+
+import "github.com/paulsmith/gogeos/geos"
+
+// ParkIndex specializes SpatialIndex for Park geometries, built once after
+// the parks are loaded. It's what QueryEngine queries instead of scanning
+// every park for every dispensary.
+type ParkIndex struct {
+	spatial *SpatialIndex
+}
+
+// NewParkIndex builds an R-tree over parks. Each park's geometry is run
+// through projection before it's indexed, so the tree's bounds and the
+// distances Nearest reports are both in projection's working CRS; Park
+// values themselves keep their original, un-projected geometry for
+// display. A nil projection indexes parks as-is (IdentityProjection).
+func NewParkIndex(parks []Park, projection Projection) (*ParkIndex, error) {
+	if projection == nil {
+		projection = IdentityProjection{}
+	}
+
+	items := make([]SpatialItem, len(parks))
+	for i, park := range parks {
+		projected, err := projection.Project(park.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = SpatialItem{Geometry: projected, Value: park}
+	}
+
+	spatial, err := NewSpatialIndex(items, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParkIndex{spatial: spatial}, nil
+}
+
+// Nearest returns the k closest parks to point, nearest first. The
+// returned ParkDistances have their store field left zero-valued; callers
+// that are scoring a particular dispensary fill it in.
+func (idx *ParkIndex) Nearest(point *geos.Geometry, k int) ([]ParkDistance, error) {
+	results, err := idx.spatial.Nearest(point, k)
+	if err != nil {
+		return nil, err
+	}
+
+	parkDistances := make([]ParkDistance, len(results))
+	for i, result := range results {
+		parkDistances[i] = ParkDistance{park: result.Value.(Park), distance: result.Distance}
+	}
+	return parkDistances, nil
+}
+
+// DispensaryIndex mirrors ParkIndex for the reverse query: given a park,
+// find its nearest dispensaries.
+type DispensaryIndex struct {
+	spatial *SpatialIndex
+}
+
+// NewDispensaryIndex builds an R-tree over dispensaries, projected the
+// same way NewParkIndex projects parks.
+func NewDispensaryIndex(dispensaries []Dispensary, projection Projection) (*DispensaryIndex, error) {
+	if projection == nil {
+		projection = IdentityProjection{}
+	}
+
+	items := make([]SpatialItem, len(dispensaries))
+	for i, store := range dispensaries {
+		projected, err := projection.Project(store.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = SpatialItem{Geometry: projected, Value: store}
+	}
+
+	spatial, err := NewSpatialIndex(items, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DispensaryIndex{spatial: spatial}, nil
+}
+
+// Nearest returns the k closest dispensaries to point, nearest first.
+func (idx *DispensaryIndex) Nearest(point *geos.Geometry, k int) ([]ParkDistance, error) {
+	results, err := idx.spatial.Nearest(point, k)
+	if err != nil {
+		return nil, err
+	}
+
+	parkDistances := make([]ParkDistance, len(results))
+	for i, result := range results {
+		parkDistances[i] = ParkDistance{store: result.Value.(Dispensary), distance: result.Distance}
+	}
+	return parkDistances, nil
+}
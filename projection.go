@@ -0,0 +1,487 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/paulsmith/gogeos/geos"
+	proj "github.com/twpayne/go-proj/v9"
+)
+
+const earthRadiusMeters = 6371000.0
+
+// HaversineMeters is the fast Point->Point path: the great-circle
+// distance between two EPSG:4326 lon/lat points, computed with plain
+// trigonometry instead of a GEOS Distance() call.
+func HaversineMeters(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1Rad := lat1 * math.Pi / 180
+	lat2Rad := lat2 * math.Pi / 180
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1Rad)*math.Cos(lat2Rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return earthRadiusMeters * c
+}
+
+// Projection reprojects geometries loaded in EPSG:4326 (lon/lat degrees)
+// into a metric working CRS, and measures distance between geometries
+// once they're in it. Built once at load time from the dataset's
+// centroid (or an explicit EPSG code), then shared by every index and
+// query the QueryEngine runs.
+type Projection interface {
+	// Project reprojects geometry from EPSG:4326 into the working CRS.
+	// Implementations that don't reproject (HaversineProjection) return
+	// geometry unchanged.
+	Project(geometry *geos.Geometry) (*geos.Geometry, error)
+
+	// Distance returns the distance, in meters, between two geometries
+	// that have already been run through Project.
+	Distance(a, b *geos.Geometry) (float64, error)
+}
+
+// NewProjection builds the Projection selected by spec:
+//   - "" or "auto": an automatic UTM zone chosen from the dataset's
+//     centroid
+//   - "haversine": a spherical Haversine fallback, left in lon/lat
+//     degrees and measured geodesically rather than reprojected
+//   - "epsg:<code>": a user-selected EPSG code, reprojected via a proj4
+//     binding
+func NewProjection(spec string, centroid geos.Coord) (Projection, error) {
+	switch {
+	case spec == "" || spec == "auto":
+		return NewUTMProjection(centroid.X, centroid.Y), nil
+	case spec == "haversine":
+		return HaversineProjection{}, nil
+	case strings.HasPrefix(strings.ToLower(spec), "epsg:"):
+		return NewEPSGProjection(spec)
+	default:
+		return nil, fmt.Errorf("unknown --projection %q (want auto, haversine, or epsg:<code>)", spec)
+	}
+}
+
+// datasetCentroid averages the centroids of every dispensary and park
+// geometry, giving NewQueryEngine a single representative point to pick a
+// UTM zone from when --projection is left at its "auto" default.
+func datasetCentroid(dispensaries Dispensaries, parks Parks) (geos.Coord, error) {
+	var sumX, sumY float64
+	var n int
+
+	accumulate := func(geometry *geos.Geometry) error {
+		centroid, err := geometry.Centroid()
+		if err != nil {
+			return err
+		}
+		x, err := centroid.X()
+		if err != nil {
+			return err
+		}
+		y, err := centroid.Y()
+		if err != nil {
+			return err
+		}
+		sumX += x
+		sumY += y
+		n++
+		return nil
+	}
+
+	for _, store := range dispensaries {
+		if err := accumulate(store.Geometry); err != nil {
+			return geos.Coord{}, err
+		}
+	}
+	for _, park := range parks {
+		if err := accumulate(park.Geometry); err != nil {
+			return geos.Coord{}, err
+		}
+	}
+
+	if n == 0 {
+		return geos.NewCoord(0, 0), nil
+	}
+	return geos.NewCoord(sumX/float64(n), sumY/float64(n)), nil
+}
+
+// projectGeometry rebuilds geometry coordinate-by-coordinate through
+// project, recursing into polygon rings and collection members. It
+// mirrors the structure the loaders (kml.go, geojson.go, shapefile.go)
+// already build geometries with, just running each coordinate through a
+// transform instead of straight from parsed floats. project can fail
+// (the EPSG path calls into proj4), so it returns an error alongside the
+// projected coordinate.
+func projectGeometry(project func(geos.Coord) (geos.Coord, error), geometry *geos.Geometry) (*geos.Geometry, error) {
+	geomType, err := geometry.Type()
+	if err != nil {
+		return nil, err
+	}
+
+	switch geomType {
+	case geos.POINT:
+		x, err := geometry.X()
+		if err != nil {
+			return nil, err
+		}
+		y, err := geometry.Y()
+		if err != nil {
+			return nil, err
+		}
+		coord, err := project(geos.NewCoord(x, y))
+		if err != nil {
+			return nil, err
+		}
+		return geos.NewPoint(coord)
+
+	case geos.POLYGON:
+		shell, err := geometry.Shell()
+		if err != nil {
+			return nil, err
+		}
+		coords, err := projectRing(project, shell)
+		if err != nil {
+			return nil, err
+		}
+		return geos.NewPolygon(coords)
+
+	case geos.MULTIPOLYGON, geos.GEOMETRYCOLLECTION:
+		n, err := geometry.NGeometry()
+		if err != nil {
+			return nil, err
+		}
+		parts := make([]*geos.Geometry, n)
+		for i := 0; i < n; i++ {
+			sub, err := geometry.Geometry(i)
+			if err != nil {
+				return nil, err
+			}
+			parts[i], err = projectGeometry(project, sub)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return geos.NewCollection(geomType, parts...)
+
+	default:
+		return nil, fmt.Errorf("projection: unsupported geometry type %v", geomType)
+	}
+}
+
+func projectRing(project func(geos.Coord) (geos.Coord, error), ring *geos.Geometry) ([]geos.Coord, error) {
+	n, err := ring.NPoint()
+	if err != nil {
+		return nil, err
+	}
+
+	coords := make([]geos.Coord, n)
+	for i := 0; i < n; i++ {
+		point, err := ring.Point(i)
+		if err != nil {
+			return nil, err
+		}
+		x, err := point.X()
+		if err != nil {
+			return nil, err
+		}
+		y, err := point.Y()
+		if err != nil {
+			return nil, err
+		}
+		coords[i], err = project(geos.NewCoord(x, y))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return coords, nil
+}
+
+// geosDistance is the plain "ask GEOS" distance, used once geometries are
+// already in a metric CRS.
+func geosDistance(a, b *geos.Geometry) (float64, error) {
+	return a.Distance(b)
+}
+
+// UTMProjection reprojects into the UTM zone covering the dataset's
+// centroid, using the standard WGS84 ellipsoidal forward formulas. Once
+// projected, plain GEOS Distance() calls return meters directly.
+type UTMProjection struct {
+	Zone     int
+	Northern bool
+}
+
+// NewUTMProjection picks the UTM zone and hemisphere for (centroidLon,
+// centroidLat).
+func NewUTMProjection(centroidLon, centroidLat float64) *UTMProjection {
+	zone := int(math.Floor((centroidLon+180)/6)) + 1
+	return &UTMProjection{Zone: zone, Northern: centroidLat >= 0}
+}
+
+const (
+	utmK0  = 0.9996
+	wgs84A = 6378137.0
+	wgs84F = 1.0 / 298.257223563
+)
+
+func (p *UTMProjection) projectCoord(c geos.Coord) (geos.Coord, error) {
+	a := wgs84A
+	f := wgs84F
+	e2 := f * (2 - f)
+	ePrimeSq := e2 / (1 - e2)
+
+	latRad := c.Y * math.Pi / 180
+	lonRad := c.X * math.Pi / 180
+	lonOrigin := float64(p.Zone-1)*6 - 180 + 3
+	lonOriginRad := lonOrigin * math.Pi / 180
+
+	sinLat := math.Sin(latRad)
+	cosLat := math.Cos(latRad)
+	tanLat := math.Tan(latRad)
+
+	N := a / math.Sqrt(1-e2*sinLat*sinLat)
+	T := tanLat * tanLat
+	C := ePrimeSq * cosLat * cosLat
+	A := cosLat * (lonRad - lonOriginRad)
+
+	M := a * ((1-e2/4-3*e2*e2/64-5*e2*e2*e2/256)*latRad -
+		(3*e2/8+3*e2*e2/32+45*e2*e2*e2/1024)*math.Sin(2*latRad) +
+		(15*e2*e2/256+45*e2*e2*e2/1024)*math.Sin(4*latRad) -
+		(35*e2*e2*e2/3072)*math.Sin(6*latRad))
+
+	easting := utmK0*N*(A+(1-T+C)*math.Pow(A, 3)/6+
+		(5-18*T+T*T+72*C-58*ePrimeSq)*math.Pow(A, 5)/120) + 500000.0
+
+	northing := utmK0 * (M + N*tanLat*(A*A/2+(5-T+9*C+4*C*C)*math.Pow(A, 4)/24+
+		(61-58*T+T*T+600*C-330*ePrimeSq)*math.Pow(A, 6)/720))
+	if !p.Northern {
+		northing += 10000000.0
+	}
+
+	return geos.NewCoord(easting, northing), nil
+}
+
+func (p *UTMProjection) Project(geometry *geos.Geometry) (*geos.Geometry, error) {
+	return projectGeometry(p.projectCoord, geometry)
+}
+
+func (p *UTMProjection) Distance(a, b *geos.Geometry) (float64, error) {
+	return geosDistance(a, b)
+}
+
+// EPSGProjection reprojects via a user-selected EPSG code, using a proj4
+// binding for the actual coordinate transform.
+type EPSGProjection struct {
+	transform *proj.Transformation
+}
+
+// NewEPSGProjection builds a transform from EPSG:4326 to spec (e.g.
+// "epsg:3857").
+func NewEPSGProjection(spec string) (*EPSGProjection, error) {
+	code := strings.TrimPrefix(strings.ToLower(spec), "epsg:")
+	if _, err := strconv.Atoi(code); err != nil {
+		return nil, fmt.Errorf("invalid EPSG code %q", spec)
+	}
+
+	transform, err := proj.NewContext().NewCRSToCRSTransformation("EPSG:4326", strings.ToUpper(spec), nil)
+	if err != nil {
+		return nil, fmt.Errorf("building proj4 transform for %s: %w", spec, err)
+	}
+
+	return &EPSGProjection{transform: transform}, nil
+}
+
+func (p *EPSGProjection) projectCoord(c geos.Coord) (geos.Coord, error) {
+	// EPSG:4326's authority-defined axis order is (lat, lon), not the
+	// GIS-conventional (lon, lat) c is stored in, and this binding does
+	// no axis normalization: feed it (lon, lat) and it silently swaps
+	// latitude and longitude rather than erroring.
+	projected, err := p.transform.Forward(proj.NewCoord(c.Y, c.X, 0, 0))
+	if err != nil {
+		return geos.Coord{}, err
+	}
+	return geos.NewCoord(projected[0], projected[1]), nil
+}
+
+func (p *EPSGProjection) Project(geometry *geos.Geometry) (*geos.Geometry, error) {
+	return projectGeometry(p.projectCoord, geometry)
+}
+
+func (p *EPSGProjection) Distance(a, b *geos.Geometry) (float64, error) {
+	return geosDistance(a, b)
+}
+
+// HaversineProjection doesn't reproject at all: geometries stay in
+// EPSG:4326 degrees, and Distance always reports meters. The fast exact
+// path covers Point->Point, the common "store to park centroid" case;
+// everything else (a park polygon, or a bounding box during R-tree
+// prefiltering) falls back to the minimum Haversine distance between
+// every vertex pair, an approximation of the true geodesic
+// point-to-geometry distance that's exact for polygons whose nearest
+// point to the other geometry is a vertex, and otherwise a close upper
+// bound. A bare GEOS Distance() (degrees) would be meaningless as
+// meters, and a centroid-to-centroid approximation would violate
+// SpatialIndex.Nearest's lower-bound invariant (see rtree.go) and let
+// Nearest prune subtrees that actually contain the true nearest park.
+type HaversineProjection struct{}
+
+func (HaversineProjection) Project(geometry *geos.Geometry) (*geos.Geometry, error) {
+	return geometry, nil
+}
+
+func (HaversineProjection) Distance(a, b *geos.Geometry) (float64, error) {
+	aType, err := a.Type()
+	if err != nil {
+		return 0, err
+	}
+	bType, err := b.Type()
+	if err != nil {
+		return 0, err
+	}
+
+	if aType == geos.POINT && bType == geos.POINT {
+		aLng, err := a.X()
+		if err != nil {
+			return 0, err
+		}
+		aLat, err := a.Y()
+		if err != nil {
+			return 0, err
+		}
+		bLng, err := b.X()
+		if err != nil {
+			return 0, err
+		}
+		bLat, err := b.Y()
+		if err != nil {
+			return 0, err
+		}
+		return HaversineMeters(aLng, aLat, bLng, bLat), nil
+	}
+
+	aVertices, err := haversineVertices(a)
+	if err != nil {
+		return 0, err
+	}
+	bVertices, err := haversineVertices(b)
+	if err != nil {
+		return 0, err
+	}
+
+	min := math.Inf(1)
+	for _, av := range aVertices {
+		for _, bv := range bVertices {
+			if d := HaversineMeters(av.X, av.Y, bv.X, bv.Y); d < min {
+				min = d
+			}
+		}
+	}
+	return min, nil
+}
+
+// haversineVertices collects every coordinate geometry is built from
+// (a point's own coordinate, a polygon's shell and holes, or a
+// collection's members' vertices recursively), for the vertex-pair
+// distance HaversineProjection.Distance falls back to.
+func haversineVertices(geometry *geos.Geometry) ([]geos.Coord, error) {
+	geomType, err := geometry.Type()
+	if err != nil {
+		return nil, err
+	}
+
+	switch geomType {
+	case geos.POINT:
+		x, err := geometry.X()
+		if err != nil {
+			return nil, err
+		}
+		y, err := geometry.Y()
+		if err != nil {
+			return nil, err
+		}
+		return []geos.Coord{geos.NewCoord(x, y)}, nil
+
+	case geos.POLYGON:
+		shell, err := geometry.Shell()
+		if err != nil {
+			return nil, err
+		}
+		vertices, err := ringVertices(shell)
+		if err != nil {
+			return nil, err
+		}
+
+		holes, err := geometry.Holes()
+		if err != nil {
+			return nil, err
+		}
+		for _, hole := range holes {
+			holeVertices, err := ringVertices(hole)
+			if err != nil {
+				return nil, err
+			}
+			vertices = append(vertices, holeVertices...)
+		}
+		return vertices, nil
+
+	case geos.MULTIPOLYGON, geos.GEOMETRYCOLLECTION:
+		n, err := geometry.NGeometry()
+		if err != nil {
+			return nil, err
+		}
+		var vertices []geos.Coord
+		for i := 0; i < n; i++ {
+			sub, err := geometry.Geometry(i)
+			if err != nil {
+				return nil, err
+			}
+			subVertices, err := haversineVertices(sub)
+			if err != nil {
+				return nil, err
+			}
+			vertices = append(vertices, subVertices...)
+		}
+		return vertices, nil
+
+	default:
+		return nil, fmt.Errorf("haversine distance: unsupported geometry type %v", geomType)
+	}
+}
+
+func ringVertices(ring *geos.Geometry) ([]geos.Coord, error) {
+	n, err := ring.NPoint()
+	if err != nil {
+		return nil, err
+	}
+
+	vertices := make([]geos.Coord, n)
+	for i := 0; i < n; i++ {
+		point, err := ring.Point(i)
+		if err != nil {
+			return nil, err
+		}
+		x, err := point.X()
+		if err != nil {
+			return nil, err
+		}
+		y, err := point.Y()
+		if err != nil {
+			return nil, err
+		}
+		vertices[i] = geos.NewCoord(x, y)
+	}
+	return vertices, nil
+}
+
+// IdentityProjection skips both reprojection and any distance override;
+// it's the R-tree's original behavior (callers, like the benchmarks, that
+// want plain GEOS Distance() without a projection pass opt into this).
+type IdentityProjection struct{}
+
+func (IdentityProjection) Project(geometry *geos.Geometry) (*geos.Geometry, error) {
+	return geometry, nil
+}
+
+func (IdentityProjection) Distance(a, b *geos.Geometry) (float64, error) {
+	return geosDistance(a, b)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulsmith/gogeos/geos"
+)
+
+// approxEqual reports whether a and b are within tol of each other.
+func approxEqual(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol
+}
+
+// TestEPSGProjectionAxisOrder checks a known WGS84 lon/lat point against
+// its EPSG:3857 (Web Mercator) reference coordinates. EPSG:4326's
+// authority axis order is (lat, lon), not the (lon, lat) this project
+// stores coordinates in, so this also guards against regressing the
+// lon/lat swap projectCoord has to apply before calling proj4.
+func TestEPSGProjectionAxisOrder(t *testing.T) {
+	projection, err := NewEPSGProjection("epsg:3857")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Denver, CO. Reference values computed from the standard spherical
+	// Web Mercator formulas EPSG:3857 is defined by.
+	const lon, lat = -104.9903, 39.7392
+	const wantX, wantY = -11687466.73, 4828115.60
+
+	point, err := geos.NewPoint(geos.NewCoord(lon, lat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projected, err := projection.Project(point)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotX, err := projected.X()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotY, err := projected.Y()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !approxEqual(gotX, wantX, 1.0) || !approxEqual(gotY, wantY, 1.0) {
+		t.Fatalf("projected (%v, %v), want (%v, %v) within 1m", gotX, gotY, wantX, wantY)
+	}
+}
+
+// TestHaversineProjectionDistanceToPolygonIsMeters checks that, for the
+// common store->park case (a point against a polygon, not the Point->
+// Point fast path), HaversineProjection.Distance still returns meters —
+// not a bare GEOS Distance() in degrees, off by a factor of ~111,000.
+func TestHaversineProjectionDistanceToPolygonIsMeters(t *testing.T) {
+	point, err := geos.NewPoint(geos.NewCoord(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A small square park whose closest corner to (0, 0) is (1, 0): one
+	// degree of longitude away along the equator.
+	park, err := geos.NewPolygon([]geos.Coord{
+		geos.NewCoord(1, 0),
+		geos.NewCoord(1.001, 0),
+		geos.NewCoord(1.001, 0.001),
+		geos.NewCoord(1, 0.001),
+		geos.NewCoord(1, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	distance, err := (HaversineProjection{}).Distance(point, park)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := HaversineMeters(0, 0, 1, 0)
+	if !approxEqual(distance, want, 1.0) {
+		t.Fatalf("distance = %v, want %v (one degree of longitude, in meters)", distance, want)
+	}
+	if distance < 1000 {
+		t.Fatalf("distance = %v looks like degrees, not meters", distance)
+	}
+}
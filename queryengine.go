@@ -0,0 +1,191 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/paulsmith/gogeos/geos"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// QueryEngine keeps a loaded dispensary/park dataset and its spatial
+// indexes in memory and answers nearest-neighbor queries against them.
+// It's the one place query logic lives, so the one-shot CLI mode and the
+// HTTP handlers can't drift apart.
+type QueryEngine struct {
+	dispensaries    Dispensaries
+	parks           Parks
+	parkIndex       *ParkIndex
+	dispensaryIndex *DispensaryIndex
+	projection      Projection
+	metrics         *Metrics
+}
+
+// NewQueryEngine builds a Projection from the dataset's centroid, builds
+// spatial indexes over dispensaries and parks projected through it, and
+// wraps them, along with the raw slices, in a QueryEngine. Index build
+// time and loaded counts are recorded to metrics as they're computed.
+// projSpec is the --projection flag value; see NewProjection.
+func NewQueryEngine(dispensaries Dispensaries, parks Parks, metrics *Metrics, projSpec string) (*QueryEngine, error) {
+	start := time.Now()
+
+	centroid, err := datasetCentroid(dispensaries, parks)
+	if err != nil {
+		return nil, err
+	}
+
+	projection, err := NewProjection(projSpec, centroid)
+	if err != nil {
+		return nil, err
+	}
+
+	parkIndex, err := NewParkIndex(parks, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	dispensaryIndex, err := NewDispensaryIndex(dispensaries, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.IndexBuildSeconds.Set(time.Since(start).Seconds())
+	metrics.DispensariesLoaded.Set(float64(len(dispensaries)))
+	metrics.ParksLoaded.Set(float64(len(parks)))
+
+	return &QueryEngine{
+		dispensaries:    dispensaries,
+		parks:           parks,
+		parkIndex:       parkIndex,
+		dispensaryIndex: dispensaryIndex,
+		projection:      projection,
+		metrics:         metrics,
+	}, nil
+}
+
+// NearestPark answers "what's near this point": it finds the k
+// dispensaries closest to point, then for each one reports its own
+// nearest park. This is what GET /nearest-park dispatches to.
+func (e *QueryEngine) NearestPark(point *geos.Geometry, k int) ([]ParkDistance, error) {
+	timer := prometheus.NewTimer(e.metrics.QueryLatency.WithLabelValues("nearest-park"))
+	defer timer.ObserveDuration()
+
+	projectedPoint, err := e.projection.Project(point)
+	if err != nil {
+		return nil, err
+	}
+
+	storeMatches, err := e.dispensaryIndex.Nearest(projectedPoint, k)
+	if err != nil {
+		return nil, err
+	}
+	e.metrics.DistanceComputations.Add(float64(len(storeMatches)))
+
+	results := make([]ParkDistance, 0, len(storeMatches))
+	for _, storeMatch := range storeMatches {
+		projectedStore, err := e.projection.Project(storeMatch.store.Geometry)
+		if err != nil {
+			return nil, err
+		}
+
+		parkMatches, err := e.parkIndex.Nearest(projectedStore, 1)
+		if err != nil {
+			return nil, err
+		}
+		e.metrics.DistanceComputations.Add(float64(len(parkMatches)))
+		if len(parkMatches) == 0 {
+			continue
+		}
+
+		nearest := parkMatches[0]
+		nearest.store = storeMatch.store
+		results = append(results, nearest)
+	}
+
+	return results, nil
+}
+
+// ParksNear returns the k parks closest to geometry's centroid, nearest
+// first. It's what GET /parks/near dispatches to for polygon queries.
+func (e *QueryEngine) ParksNear(geometry *geos.Geometry, k int) ([]ParkDistance, error) {
+	timer := prometheus.NewTimer(e.metrics.QueryLatency.WithLabelValues("parks-near"))
+	defer timer.ObserveDuration()
+
+	centroid, err := geometry.Centroid()
+	if err != nil {
+		return nil, err
+	}
+
+	projectedCentroid, err := e.projection.Project(centroid)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := e.parkIndex.Nearest(projectedCentroid, k)
+	if err != nil {
+		return nil, err
+	}
+	e.metrics.DistanceComputations.Add(float64(len(matches)))
+
+	return matches, nil
+}
+
+// NearestOverall runs the original worker-pool pipeline: every dispensary
+// is matched against its nearest park concurrently, and the single
+// closest store/park pair across the whole dataset comes out the other
+// end. This is what one-shot CLI mode prints.
+func (e *QueryEngine) NearestOverall() (*ParkDistance, error) {
+	timer := prometheus.NewTimer(e.metrics.QueryLatency.WithLabelValues("nearest-overall"))
+	defer timer.ObserveDuration()
+
+	storeCount := len(e.dispensaries)
+	workQueue := make(chan Dispensary, storeCount)
+	results := make(chan *ParkDistance, storeCount)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go e.nearestParkWorker(&wg, workQueue, results)
+	}
+
+	finalResult := nearestPair(&wg, results)
+
+	for _, store := range e.dispensaries {
+		workQueue <- store
+	}
+	close(workQueue)
+
+	wg.Wait()
+	close(results)
+
+	return <-finalResult, nil
+}
+
+// nearestParkWorker is NearestOverall's per-goroutine worker: for every
+// dispensary read from input, it queries the shared park index and pushes
+// the best match to output.
+func (e *QueryEngine) nearestParkWorker(wg *sync.WaitGroup, input <-chan Dispensary, output chan<- *ParkDistance) {
+	defer wg.Done()
+
+	for store := range input {
+		projectedStore, err := e.projection.Project(store.Geometry)
+		if err != nil {
+			e.metrics.WorkerJobsProcessed.Inc()
+			continue
+		}
+
+		matches, err := e.parkIndex.Nearest(projectedStore, 1)
+		e.metrics.WorkerJobsProcessed.Inc()
+		if err != nil {
+			continue
+		}
+		e.metrics.DistanceComputations.Add(float64(len(matches)))
+		if len(matches) == 0 {
+			continue
+		}
+
+		nearest := matches[0]
+		nearest.store = store
+		output <- &nearest
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/paulsmith/gogeos/geos"
+)
+
+// rtreeNodeCapacity bounds how many children/items a node holds before a
+// parent is expected to split it into siblings. Kept small since the
+// dataset this loads is small (city-scale KML exports, not planet-scale
+// OSM extracts).
+const rtreeNodeCapacity = 8
+
+// SpatialItem pairs an indexed geometry with an arbitrary payload. It's
+// what callers hand to NewSpatialIndex to build the tree.
+type SpatialItem struct {
+	Geometry *geos.Geometry
+	Value    interface{}
+}
+
+// SpatialResult is a single hit from a nearest-neighbor query: the value
+// that was indexed alongside the geometry, and its distance from the
+// query point.
+type SpatialResult struct {
+	Value    interface{}
+	Distance float64
+}
+
+// rtreeNode is either an internal node (children set, items nil) or a
+// leaf (items set, children nil). bounds is the envelope covering
+// everything beneath the node, used to prefilter subtrees during a
+// nearest-neighbor walk before paying for a real Distance() call.
+type rtreeNode struct {
+	bounds   *geos.Geometry
+	children []*rtreeNode
+	items    []SpatialItem
+}
+
+// SpatialIndex is an in-memory R-tree over geometries. It's deliberately
+// generic over the indexed value so it can hold Parks for
+// dispensary->park queries or Dispensaries for the reverse.
+type SpatialIndex struct {
+	root       *rtreeNode
+	projection Projection
+}
+
+// NewSpatialIndex bulk-builds an R-tree from items. Building is a one-time
+// cost paid once after the source KML/GeoJSON/etc. is loaded; queries
+// against the result are the hot path.
+//
+// Distances, both for the bounding-box prefilter and for the leaf-level
+// results Nearest returns, are computed through projection rather than a
+// bare GEOS Distance() call, so the index reports true metric distances
+// once items and query points have been run through the same Projection.
+// A nil projection falls back to IdentityProjection, matching this
+// index's original GEOS-only behavior.
+func NewSpatialIndex(items []SpatialItem, projection Projection) (*SpatialIndex, error) {
+	if projection == nil {
+		projection = IdentityProjection{}
+	}
+	if len(items) == 0 {
+		return &SpatialIndex{projection: projection}, nil
+	}
+
+	leaves := make([]*rtreeNode, len(items))
+	for i, item := range items {
+		bounds, err := item.Geometry.Envelope()
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = &rtreeNode{bounds: bounds, items: []SpatialItem{item}}
+	}
+
+	root, err := buildRtreeLevel(leaves)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SpatialIndex{root: root, projection: projection}, nil
+}
+
+// buildRtreeLevel groups nodes into rtreeNodeCapacity-sized buckets sorted
+// by their bounds' centroid X coordinate (a simple sort-tile bulk load),
+// wraps each bucket in a parent node, and recurses until a single root
+// remains.
+func buildRtreeLevel(nodes []*rtreeNode) (*rtreeNode, error) {
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+
+	sorted, err := sortNodesByCentroidX(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var parents []*rtreeNode
+	for i := 0; i < len(sorted); i += rtreeNodeCapacity {
+		end := i + rtreeNodeCapacity
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		group := sorted[i:end]
+
+		bounds, err := unionBounds(group)
+		if err != nil {
+			return nil, err
+		}
+
+		parents = append(parents, &rtreeNode{bounds: bounds, children: group})
+	}
+
+	return buildRtreeLevel(parents)
+}
+
+func sortNodesByCentroidX(nodes []*rtreeNode) ([]*rtreeNode, error) {
+	type keyed struct {
+		node *rtreeNode
+		x    float64
+	}
+
+	keys := make([]keyed, len(nodes))
+	for i, node := range nodes {
+		centroid, err := node.bounds.Centroid()
+		if err != nil {
+			return nil, err
+		}
+		x, err := centroid.X()
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = keyed{node: node, x: x}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].x < keys[j].x })
+
+	sorted := make([]*rtreeNode, len(keys))
+	for i, k := range keys {
+		sorted[i] = k.node
+	}
+	return sorted, nil
+}
+
+// unionBounds merges the bounds of a group of nodes into a single envelope
+// that covers all of them.
+func unionBounds(group []*rtreeNode) (*geos.Geometry, error) {
+	merged := group[0].bounds
+	for _, node := range group[1:] {
+		union, err := merged.Union(node.bounds)
+		if err != nil {
+			return nil, err
+		}
+		merged, err = union.Envelope()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// Nearest returns the k closest indexed items to point, nearest first. It
+// walks the tree in bounding-box order: a node's envelope distance to the
+// query point is always a lower bound on the true distance to anything
+// inside it, so subtrees farther than the current k-th best are skipped
+// without ever calling Distance() on their contents.
+func (idx *SpatialIndex) Nearest(point *geos.Geometry, k int) ([]SpatialResult, error) {
+	if idx.root == nil || k <= 0 {
+		return nil, nil
+	}
+
+	best := make([]SpatialResult, 0, k)
+
+	var visit func(node *rtreeNode) error
+	visit = func(node *rtreeNode) error {
+		boxDistance, err := idx.projection.Distance(point, node.bounds)
+		if err != nil {
+			return err
+		}
+		if len(best) == k && boxDistance >= best[len(best)-1].Distance {
+			// Everything under this node is farther than our current
+			// worst kept result; the envelope prefilter lets us skip it
+			// wholesale.
+			return nil
+		}
+
+		if node.items != nil {
+			for _, item := range node.items {
+				distance, err := idx.projection.Distance(point, item.Geometry)
+				if err != nil {
+					return err
+				}
+				best = insertSorted(best, SpatialResult{Value: item.Value, Distance: distance}, k)
+			}
+			return nil
+		}
+
+		for _, child := range node.children {
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := visit(idx.root); err != nil {
+		return nil, err
+	}
+
+	return best, nil
+}
+
+// insertSorted inserts result into best (kept sorted ascending by
+// distance), trimming to at most k entries.
+func insertSorted(best []SpatialResult, result SpatialResult, k int) []SpatialResult {
+	i := sort.Search(len(best), func(i int) bool { return best[i].Distance > result.Distance })
+	best = append(best, SpatialResult{})
+	copy(best[i+1:], best[i:])
+	best[i] = result
+	if len(best) > k {
+		best = best[:k]
+	}
+	return best
+}
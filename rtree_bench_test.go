@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/paulsmith/gogeos/geos"
+)
+
+// makeBenchParks scatters n small square parks across a 1 degree x 1
+// degree area, roughly the footprint of a real city KML export.
+func makeBenchParks(n int) []Park {
+	rng := rand.New(rand.NewSource(1))
+	parks := make([]Park, n)
+	for i := 0; i < n; i++ {
+		x := rng.Float64()
+		y := rng.Float64()
+		coords := []geos.Coord{
+			geos.NewCoord(x, y),
+			geos.NewCoord(x+0.001, y),
+			geos.NewCoord(x+0.001, y+0.001),
+			geos.NewCoord(x, y+0.001),
+			geos.NewCoord(x, y),
+		}
+		geometry, err := geos.NewPolygon(coords)
+		if err != nil {
+			panic(err)
+		}
+		parks[i] = Park{Placemark: Placemark{Name: fmt.Sprintf("park-%d", i), Geometry: geometry}}
+	}
+	return parks
+}
+
+func linearNearestPark(point *geos.Geometry, parks []Park) (*ParkDistance, error) {
+	var nearest *ParkDistance
+	for _, park := range parks {
+		distance, err := point.Distance(park.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		if nearest == nil || distance < nearest.distance {
+			nearest = &ParkDistance{park: park, distance: distance}
+		}
+	}
+	return nearest, nil
+}
+
+func benchmarkLinearScan(b *testing.B, parkCount int) {
+	parks := makeBenchParks(parkCount)
+	point, err := geos.NewPoint(geos.NewCoord(0.5, 0.5))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := linearNearestPark(point, parks); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkRTree(b *testing.B, parkCount int) {
+	parks := makeBenchParks(parkCount)
+	index, err := NewParkIndex(parks, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	point, err := geos.NewPoint(geos.NewCoord(0.5, 0.5))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := index.Nearest(point, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLinearScan100(b *testing.B)   { benchmarkLinearScan(b, 100) }
+func BenchmarkLinearScan1000(b *testing.B)  { benchmarkLinearScan(b, 1000) }
+func BenchmarkLinearScan10000(b *testing.B) { benchmarkLinearScan(b, 10000) }
+
+func BenchmarkRTree100(b *testing.B)   { benchmarkRTree(b, 100) }
+func BenchmarkRTree1000(b *testing.B)  { benchmarkRTree(b, 1000) }
+func BenchmarkRTree10000(b *testing.B) { benchmarkRTree(b, 10000) }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/paulsmith/gogeos/geos"
+)
+
+// bruteForceNearestParks scans every park and sorts by projection's notion
+// of distance from point, the ground truth SpatialIndex.Nearest is checked
+// against below.
+func bruteForceNearestParks(projection Projection, point *geos.Geometry, parks []Park, k int) ([]ParkDistance, error) {
+	results := make([]ParkDistance, len(parks))
+	for i, park := range parks {
+		distance, err := projection.Distance(point, park.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = ParkDistance{park: park, distance: distance}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].distance < results[j].distance })
+	if k < len(results) {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// TestSpatialIndexNearestMatchesLinearScan checks the R-tree's k-NN walk
+// against a brute-force scan over the same parks, under plain GEOS
+// distance (IdentityProjection) where a park is a polygon rather than a
+// point.
+func TestSpatialIndexNearestMatchesLinearScan(t *testing.T) {
+	parks := makeBenchParks(200)
+	index, err := NewParkIndex(parks, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 20; i++ {
+		point, err := geos.NewPoint(geos.NewCoord(rng.Float64(), rng.Float64()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, k := range []int{1, 3} {
+			got, err := index.Nearest(point, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := bruteForceNearestParks(IdentityProjection{}, point, parks, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("k=%d: got %d results, want %d", k, len(got), len(want))
+			}
+			for j := range want {
+				if got[j].park.Name != want[j].park.Name || got[j].distance != want[j].distance {
+					t.Fatalf("k=%d result %d: got park %q dist %v, want park %q dist %v",
+						k, j, got[j].park.Name, got[j].distance, want[j].park.Name, want[j].distance)
+				}
+			}
+		}
+	}
+}
+
+// TestSpatialIndexNearestWithHaversineProjection exercises the R-tree
+// under HaversineProjection against parks (polygons, not points): the
+// prefilter distance HaversineProjection.Distance reports for a
+// park's envelope must stay a lower bound on the true distance to the
+// park, or Nearest can prune the subtree containing the real answer.
+// A centroid-based approximation (see projection.go) breaks that
+// invariant and this test catches it.
+func TestSpatialIndexNearestWithHaversineProjection(t *testing.T) {
+	parks := makeBenchParks(200)
+	projection := HaversineProjection{}
+	index, err := NewParkIndex(parks, projection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 20; i++ {
+		point, err := geos.NewPoint(geos.NewCoord(rng.Float64(), rng.Float64()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := index.Nearest(point, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := bruteForceNearestParks(projection, point, parks, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 1 || len(want) != 1 {
+			t.Fatalf("expected exactly one result, got %d want %d", len(got), len(want))
+		}
+		if got[0].park.Name != want[0].park.Name {
+			t.Fatalf("got nearest park %q (dist %v), want %q (dist %v)",
+				got[0].park.Name, got[0].distance, want[0].park.Name, want[0].distance)
+		}
+	}
+}
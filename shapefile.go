@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/paulsmith/gogeos/geos"
+)
+
+// ShapefileLoader decodes ESRI Shapefile (.shp) geometry streams. The
+// PlacemarkLoader interface only hands loaders a single io.Reader, so
+// unlike a full shapefile reader this doesn't pull attributes from a
+// companion .dbf — Description/Address are left blank, and Name is a
+// placeholder ("dispensary-N" or "park-N", N being the shape's position
+// among the file's non-null records). Callers that need those fields
+// populated should prefer KML or GeoJSON.
+type ShapefileLoader struct{}
+
+const (
+	shpShapeTypeNull    = 0
+	shpShapeTypePoint   = 1
+	shpShapeTypePolygon = 5
+)
+
+type shpPoint struct{ x, y float64 }
+
+type shpPolygon struct {
+	parts  []int32
+	points [][2]float64
+}
+
+func (l ShapefileLoader) LoadDispensaries(r io.Reader) (Dispensaries, error) {
+	shapes, err := readShapefile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var dispensaries Dispensaries
+	for i, shape := range shapes {
+		point, ok := shape.(shpPoint)
+		if !ok {
+			continue
+		}
+
+		geometry, err := geos.NewPoint(geos.NewCoord(point.x, point.y))
+		if err != nil {
+			return nil, err
+		}
+
+		dispensaries = append(dispensaries, Dispensary{Placemark: Placemark{
+			Name:     fmt.Sprintf("dispensary-%d", i),
+			Geometry: geometry,
+		}})
+	}
+	return dispensaries, nil
+}
+
+func (l ShapefileLoader) LoadParks(r io.Reader) (Parks, error) {
+	shapes, err := readShapefile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var parks Parks
+	for i, shape := range shapes {
+		polygon, ok := shape.(shpPolygon)
+		if !ok {
+			continue
+		}
+
+		geometry, err := shpPolygonToGeometry(polygon)
+		if err != nil {
+			return nil, err
+		}
+
+		parks = append(parks, Park{Placemark: Placemark{
+			Name:     fmt.Sprintf("park-%d", i),
+			Geometry: geometry,
+		}})
+	}
+	return parks, nil
+}
+
+// readShapefile parses the 100-byte shapefile header followed by a
+// sequence of (8-byte big-endian record header, shape content) records,
+// per the ESRI shapefile spec.
+func readShapefile(r io.Reader) ([]interface{}, error) {
+	br := bufio.NewReader(r)
+
+	var header [100]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, err
+	}
+
+	var shapes []interface{}
+	for {
+		var recordHeader [8]byte
+		if _, err := io.ReadFull(br, recordHeader[:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		contentWords := binary.BigEndian.Uint32(recordHeader[4:8])
+		content := make([]byte, contentWords*2)
+		if _, err := io.ReadFull(br, content); err != nil {
+			return nil, err
+		}
+
+		shapeType := binary.LittleEndian.Uint32(content[0:4])
+		switch shapeType {
+		case shpShapeTypeNull:
+			continue
+		case shpShapeTypePoint:
+			shapes = append(shapes, shpPoint{
+				x: math.Float64frombits(binary.LittleEndian.Uint64(content[4:12])),
+				y: math.Float64frombits(binary.LittleEndian.Uint64(content[12:20])),
+			})
+		case shpShapeTypePolygon:
+			polygon, err := parseShpPolygon(content)
+			if err != nil {
+				return nil, err
+			}
+			shapes = append(shapes, polygon)
+		default:
+			return nil, fmt.Errorf("unsupported shapefile shape type %d", shapeType)
+		}
+	}
+
+	return shapes, nil
+}
+
+func parseShpPolygon(content []byte) (shpPolygon, error) {
+	// content[4:36] holds the record's bounding box, which we don't need.
+	numParts := int32(binary.LittleEndian.Uint32(content[36:40]))
+	numPoints := int32(binary.LittleEndian.Uint32(content[40:44]))
+
+	partsStart := 44
+	parts := make([]int32, numParts)
+	for i := range parts {
+		offset := partsStart + i*4
+		parts[i] = int32(binary.LittleEndian.Uint32(content[offset : offset+4]))
+	}
+
+	pointsStart := partsStart + int(numParts)*4
+	points := make([][2]float64, numPoints)
+	for i := range points {
+		offset := pointsStart + i*16
+		points[i] = [2]float64{
+			math.Float64frombits(binary.LittleEndian.Uint64(content[offset : offset+8])),
+			math.Float64frombits(binary.LittleEndian.Uint64(content[offset+8 : offset+16])),
+		}
+	}
+
+	return shpPolygon{parts: parts, points: points}, nil
+}
+
+// shpPolygonToGeometry builds a geos geometry from a shapefile polygon
+// record. Per the ESRI spec, a record's rings aren't all independent
+// exterior boundaries: a clockwise ring starts a new outer boundary and
+// every counter-clockwise ring that follows it is a hole punched in that
+// boundary, not a separate polygon. Rings with more than one outer
+// boundary are collected into a MultiPolygon, matching how the KML and
+// GeoJSON loaders handle the same case.
+func shpPolygonToGeometry(polygon shpPolygon) (*geos.Geometry, error) {
+	rings := make([][][2]float64, len(polygon.parts))
+	for i, start := range polygon.parts {
+		end := int32(len(polygon.points))
+		if i+1 < len(polygon.parts) {
+			end = polygon.parts[i+1]
+		}
+		rings[i] = polygon.points[start:end]
+	}
+
+	var polygons []*geos.Geometry
+	var shell []geos.Coord
+	var holes [][]geos.Coord
+
+	flush := func() error {
+		if shell == nil {
+			return nil
+		}
+		geometry, err := geos.NewPolygon(shell, holes...)
+		if err != nil {
+			return err
+		}
+		polygons = append(polygons, geometry)
+		shell, holes = nil, nil
+		return nil
+	}
+
+	for _, ring := range rings {
+		coords := make([]geos.Coord, len(ring))
+		for i, coord := range ring {
+			coords[i] = geos.NewCoord(coord[0], coord[1])
+		}
+
+		if shpRingIsClockwise(ring) || shell == nil {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			shell = coords
+		} else {
+			holes = append(holes, coords)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if len(polygons) == 1 {
+		return polygons[0], nil
+	}
+	return geos.NewCollection(geos.MULTIPOLYGON, polygons...)
+}
+
+// shpRingIsClockwise reports whether ring winds clockwise, the ESRI
+// shapefile convention for an outer boundary (a counter-clockwise ring
+// is a hole in the preceding outer boundary). It uses the sign of the
+// ring's signed area, via the shoelace formula.
+func shpRingIsClockwise(ring [][2]float64) bool {
+	var signedArea float64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		signedArea += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return signedArea < 0
+}